@@ -0,0 +1,206 @@
+package flatfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+
+	datastore "github.com/ipfs/go-datastore"
+)
+
+// ChecksumAlgo selects how (if at all) flatfs protects data files against
+// bit rot and truncation.
+type ChecksumAlgo int
+
+const (
+	// NoChecksum stores values exactly as given, with no integrity
+	// framing. This is the default, matching flatfs's historical
+	// on-disk format.
+	NoChecksum ChecksumAlgo = iota
+
+	// CRC32C appends a 4-byte Castagnoli CRC32 of the value to every
+	// data file, verified on Get.
+	CRC32C
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is wrapped by ErrCorrupted when a data file's
+// trailing checksum doesn't match its contents.
+var ErrChecksumMismatch = errors.New("flatfs: checksum mismatch")
+
+// ErrTruncated is wrapped by ErrCorrupted when a data file is too short
+// to even contain the checksum trailer it's supposed to have.
+var ErrTruncated = errors.New("flatfs: truncated data file")
+
+// ErrOrphan is reported by Scrub for a shard directory entry that isn't
+// a decodable key's data file: a stray temp file left behind by a
+// crashed Put, or anything else unexpected that's found alongside the
+// data files.
+var ErrOrphan = errors.New("flatfs: orphaned entry in shard directory")
+
+// ErrCorrupted is returned by Get (and reported by Scrub) when a data
+// file fails its integrity check.
+type ErrCorrupted struct {
+	Key  datastore.Key
+	Path string
+	Err  error
+}
+
+func (e *ErrCorrupted) Error() string {
+	return fmt.Sprintf("flatfs: corrupted data for %q at %s: %v", e.Key, e.Path, e.Err)
+}
+
+func (e *ErrCorrupted) Unwrap() error { return e.Err }
+
+// frame returns the bytes that should actually be written to disk for
+// val, with a trailing checksum appended if the algorithm calls for one.
+func (a ChecksumAlgo) frame(val []byte) []byte {
+	if a != CRC32C {
+		return val
+	}
+	sum := crc32.Checksum(val, crc32cTable)
+	out := make([]byte, len(val)+4)
+	copy(out, val)
+	binary.BigEndian.PutUint32(out[len(val):], sum)
+	return out
+}
+
+// unframe reverses frame, verifying the checksum (if any) and returning
+// the original value.
+func (a ChecksumAlgo) unframe(framed []byte) ([]byte, error) {
+	if a != CRC32C {
+		return framed, nil
+	}
+	if len(framed) < 4 {
+		return nil, ErrTruncated
+	}
+	val, wantSum := framed[:len(framed)-4], framed[len(framed)-4:]
+	gotSum := crc32.Checksum(val, crc32cTable)
+	if binary.BigEndian.Uint32(wantSum) != gotSum {
+		return nil, ErrChecksumMismatch
+	}
+	return val, nil
+}
+
+// Option configures a Datastore at construction time; pass zero or more
+// to New/NewWithFS.
+type Option func(*Datastore)
+
+// WithChecksum enables per-value integrity checking using algo. It must
+// be passed consistently across the lifetime of a given datastore
+// directory: switching algorithms on a directory with existing data
+// makes old entries fail checksum verification (or, going from checksums
+// to none, leaves the trailer as part of the value).
+func WithChecksum(algo ChecksumAlgo) Option {
+	return func(fs *Datastore) {
+		fs.checksum = algo
+	}
+}
+
+// ScrubOptions configures a Scrub run. It's currently empty but kept as
+// a struct so options can be added without breaking callers.
+type ScrubOptions struct{}
+
+// ScrubResult reports the outcome of checking a single key's data file.
+// Err is nil for entries that passed verification.
+type ScrubResult struct {
+	Key  datastore.Key
+	Path string
+	Err  error
+}
+
+// Scrub walks every shard directory, reads each data file, and re-checks
+// its integrity, reporting one ScrubResult per entry on the returned
+// channel (both healthy and corrupt/truncated ones, so callers can track
+// progress), plus one ScrubResult per orphaned entry it finds along the
+// way (ErrOrphan). The walk stops early if ctx is canceled. The
+// corrupt/truncated checks only verify what Get already would; running
+// them is only meaningful when the datastore was opened with
+// WithChecksum, but orphan detection applies regardless.
+//
+// If the walk itself fails to complete — ctx is canceled, or a shard
+// directory can't be read — a final ScrubResult carrying that error (and
+// a zero Key/Path) is sent before the channel closes, the same way Query
+// reports its own walk errors, so callers can tell an aborted scan from
+// a complete one instead of the channel just closing early either way.
+func (fs *Datastore) Scrub(ctx context.Context, opts ScrubOptions) (<-chan ScrubResult, error) {
+	out := make(chan ScrubResult)
+	go func() {
+		defer close(out)
+		err := fs.scrubWalk(fs.path,
+			func(key datastore.Key, path string) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				res := ScrubResult{Key: key, Path: path}
+				if _, err := fs.Get(key); err != nil {
+					res.Err = err
+				}
+
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				return nil
+			},
+			func(path string) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				select {
+				case out <- ScrubResult{Path: path, Err: ErrOrphan}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			out <- ScrubResult{Err: err}
+		}
+	}()
+	return out, nil
+}
+
+// scrubWalk is like walk, but also calls visitOrphan for any directory
+// entry that isn't a decodable key's data file, so Scrub can flag it
+// instead of silently dropping it the way a normal Query would. The
+// SHARDING and _README bookkeeping files (and, at the datastore root,
+// Snapshot's shadow trees) are still skipped, since those are expected,
+// not orphans.
+func (fs *Datastore) scrubWalk(dir string, visitKey func(datastore.Key, string) error, visitOrphan func(string) error) error {
+	entries, err := fs.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if dir == fs.path && (entry.Name() == snapshotsDir || entry.Name() == shardingFile || entry.Name() == readmeFile) {
+			continue
+		}
+		full := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := fs.scrubWalk(full, visitKey, visitOrphan); err != nil {
+				return err
+			}
+			continue
+		}
+		key, ok := fs.decode(entry.Name())
+		if !ok {
+			if err := visitOrphan(full); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := visitKey(key, full); err != nil {
+			return err
+		}
+	}
+	return nil
+}
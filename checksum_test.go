@@ -0,0 +1,184 @@
+package flatfs_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+)
+
+func TestChecksumDetectsBitFlip(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false, flatfs.WithChecksum(flatfs.CRC32C))
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	key := datastore.NewKey("quux")
+	if err := fs.Put(key, []byte("foobar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	// Sanity check: an unmodified value still round-trips.
+	if _, err := fs.Get(key); err != nil {
+		t.Fatalf("Get of healthy value failed: %v\n", err)
+	}
+
+	target := filepath.Join(temp, "qu", "quux.data")
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatalf("could not read data file: %v\n", err)
+	}
+	data[0] ^= 0xff // flip a bit in the value, leaving the checksum stale
+	if err := ioutil.WriteFile(target, data, 0640); err != nil {
+		t.Fatalf("could not corrupt data file: %v\n", err)
+	}
+
+	_, err = fs.Get(key)
+	corrupted, ok := err.(*flatfs.ErrCorrupted)
+	if !ok {
+		t.Fatalf("expected *flatfs.ErrCorrupted, got %T: %v", err, err)
+	}
+	if corrupted.Key != key {
+		t.Errorf("ErrCorrupted.Key = %v, want %v", corrupted.Key, key)
+	}
+}
+
+func TestScrubReportsCorruption(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false, flatfs.WithChecksum(flatfs.CRC32C))
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	good := datastore.NewKey("good")
+	bad := datastore.NewKey("quux")
+	if err := fs.Put(good, []byte("healthy")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+	if err := fs.Put(bad, []byte("foobar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	target := filepath.Join(temp, "qu", "quux.data")
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatalf("could not read data file: %v\n", err)
+	}
+	data[0] ^= 0xff
+	if err := ioutil.WriteFile(target, data, 0640); err != nil {
+		t.Fatalf("could not corrupt data file: %v\n", err)
+	}
+
+	results, err := fs.Scrub(context.Background(), flatfs.ScrubOptions{})
+	if err != nil {
+		t.Fatalf("Scrub fail: %v\n", err)
+	}
+
+	sawBad, sawGood := false, false
+	for res := range results {
+		switch res.Key {
+		case bad:
+			sawBad = true
+			if res.Err == nil {
+				t.Errorf("expected Scrub to flag %v as corrupt", bad)
+			}
+		case good:
+			sawGood = true
+			if res.Err != nil {
+				t.Errorf("expected Scrub to report %v healthy, got: %v", good, res.Err)
+			}
+		}
+	}
+	if !sawBad || !sawGood {
+		t.Errorf("Scrub did not report both entries: sawBad=%v sawGood=%v", sawBad, sawGood)
+	}
+}
+
+func TestScrubReportsOrphan(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	good := datastore.NewKey("good")
+	if err := fs.Put(good, []byte("healthy")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	// Simulate a stray temp file left behind by a crashed Put: it lands
+	// in the same shard directory as "good" but doesn't decode as a key.
+	orphan := filepath.Join(temp, "go", "put-leftover")
+	if err := ioutil.WriteFile(orphan, []byte("garbage"), 0640); err != nil {
+		t.Fatalf("could not write orphan file: %v\n", err)
+	}
+
+	results, err := fs.Scrub(context.Background(), flatfs.ScrubOptions{})
+	if err != nil {
+		t.Fatalf("Scrub fail: %v\n", err)
+	}
+
+	sawGood, sawOrphan := false, false
+	for res := range results {
+		switch {
+		case res.Key == good:
+			sawGood = true
+			if res.Err != nil {
+				t.Errorf("expected Scrub to report %v healthy, got: %v", good, res.Err)
+			}
+		case res.Path == orphan:
+			sawOrphan = true
+			if res.Err != flatfs.ErrOrphan {
+				t.Errorf("expected orphan entry to be flagged with ErrOrphan, got: %v", res.Err)
+			}
+		}
+	}
+	if !sawGood || !sawOrphan {
+		t.Errorf("Scrub did not report both entries: sawGood=%v sawOrphan=%v", sawGood, sawOrphan)
+	}
+}
+
+func TestScrubReportsWalkError(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	if err := fs.Put(datastore.NewKey("quux"), []byte("foobar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := fs.Scrub(ctx, flatfs.ScrubOptions{})
+	if err != nil {
+		t.Fatalf("Scrub fail: %v\n", err)
+	}
+
+	sawWalkErr := false
+	for res := range results {
+		if res.Key == (datastore.Key{}) && res.Path == "" && res.Err != nil {
+			sawWalkErr = true
+			if res.Err != context.Canceled {
+				t.Errorf("final ScrubResult.Err = %v, want context.Canceled", res.Err)
+			}
+		}
+	}
+	if !sawWalkErr {
+		t.Error("expected Scrub to report a final ScrubResult when the walk aborted early, but it closed the channel silently")
+	}
+}
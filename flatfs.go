@@ -0,0 +1,585 @@
+// Package flatfs is a Datastore implementation that stores every value
+// as its own file on disk, sharded into subdirectories so that no single
+// directory ends up holding an unmanageable number of entries.
+package flatfs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+
+	"github.com/ipfs/go-ds-flatfs/shard"
+)
+
+const (
+	extension      = ".data"
+	shardingFile   = "SHARDING"
+	readmeFile     = "_README"
+	filePermission = 0640
+	dirPermission  = 0755
+)
+
+// IPFS_DEF_SHARD is the sharding function go-ipfs repos use when none is
+// specified explicitly.
+const IPFS_DEF_SHARD = "/repo/flatfs/shard/v1/next-to-last/2"
+
+const readmeText = `This directory is managed by go-ds-flatfs, a datastore
+that stores each value as a file on disk, sharded into subdirectories
+named by the SHARDING function recorded in this directory's SHARDING
+file. Don't delete or rename the SHARDING file; doing so will make this
+directory unreadable to flatfs.
+`
+
+var (
+	// ErrDatastoreExists is returned when New/NewWithFS is asked to open
+	// a path whose existing SHARDING file names a different function
+	// than the one requested.
+	ErrDatastoreExists = errors.New("flatfs: datastore dir already exists with a different sharding function")
+
+	// ErrClosed is returned by operations attempted after Close.
+	ErrClosed = errors.New("flatfs: datastore closed")
+)
+
+// Datastore is a Datastore implementation that stores values as files,
+// one per key, inside a tree of shard directories under path.
+type Datastore struct {
+	path string
+	fs   FS
+
+	shardStr string
+	getDir   shard.Fun
+
+	sync bool
+
+	checksum ChecksumAlgo
+
+	// snapLock guards snapSeq, which Snapshot uses to give every shadow
+	// tree it builds (see snapshot.go) a distinct directory under
+	// snapshotsDir.
+	snapLock sync.Mutex
+	snapSeq  uint64
+
+	observer Observer
+
+	shutdownLock sync.RWMutex
+	shutdown     bool
+}
+
+// snapshotsDir holds the shadow trees of hardlinks built by Snapshot,
+// one subdirectory per snapshot. walk skips it when iterating the live
+// tree so a snapshot's own files never show up in a normal Query.
+const snapshotsDir = ".snapshots"
+
+var _ datastore.Datastore = (*Datastore)(nil)
+var _ datastore.Batching = (*Datastore)(nil)
+
+// New opens (creating if necessary) a flatfs datastore rooted at path,
+// sharding keys with fun (e.g. "prefix/2", "suffix/2", "next-to-last/2",
+// or "auto" to reuse whatever an existing SHARDING file specifies). If
+// sync is true, every Put fsyncs its data file before renaming it into
+// place.
+func New(path string, fun string, sync bool, opts ...Option) (*Datastore, error) {
+	return NewWithFS(OSBackend{}, path, fun, sync, opts...)
+}
+
+// NewWithFS is like New but takes the FS backend to use instead of the
+// real filesystem, letting callers plug in an in-memory backend for
+// fast tests, a chroot/basepath wrapper for jailing, or a remote/cloud
+// store.
+func NewWithFS(bfs FS, path string, fun string, sync bool, opts ...Option) (*Datastore, error) {
+	if err := bfs.MkdirAll(path, dirPermission); err != nil {
+		return nil, err
+	}
+
+	shardId, err := ensureShardingFile(bfs, path, fun)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &Datastore{
+		path:     path,
+		fs:       bfs,
+		shardStr: shardId.String(),
+		getDir:   shardId.Fun,
+		sync:     sync,
+		observer: noopObserver{},
+	}
+	for _, opt := range opts {
+		opt(fs)
+	}
+	return fs, nil
+}
+
+// ensureShardingFile reconciles fun against any SHARDING file already
+// present at path, writing one (and, for the default function, a
+// _README) if this is a fresh directory.
+func ensureShardingFile(bfs FS, path string, fun string) (*shard.Shard, error) {
+	shardingPath := filepath.Join(path, shardingFile)
+
+	data, err := bfs.ReadFile(shardingPath)
+	switch {
+	case err == nil:
+		existing, err := shard.ParseShardFunc(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("flatfs: invalid SHARDING file: %v", err)
+		}
+		if fun == "" || fun == "auto" {
+			return existing, nil
+		}
+		wanted, err := parseShardSpec(fun)
+		if err != nil {
+			return nil, err
+		}
+		if wanted.String() != existing.String() {
+			return nil, ErrDatastoreExists
+		}
+		return existing, nil
+
+	case os.IsNotExist(err):
+		if fun == "" || fun == "auto" {
+			fun = IPFS_DEF_SHARD
+		}
+		shardId, err := parseShardSpec(fun)
+		if err != nil {
+			return nil, err
+		}
+		if err := bfs.WriteFile(shardingPath, []byte(shardId.String()+"\n"), filePermission); err != nil {
+			return nil, err
+		}
+		if shardId.String() == IPFS_DEF_SHARD {
+			if err := bfs.WriteFile(filepath.Join(path, readmeFile), []byte(readmeText), filePermission); err != nil {
+				return nil, err
+			}
+		}
+		return shardId, nil
+
+	default:
+		return nil, err
+	}
+}
+
+func parseShardSpec(fun string) (*shard.Shard, error) {
+	if strings.HasPrefix(fun, "/repo/flatfs/shard/v1/") {
+		return shard.ParseShardFunc(fun)
+	}
+	return shard.Parse(fun)
+}
+
+// ShardFunc returns the canonical, SHARDING-file form of the sharding
+// function this datastore was opened with.
+func (fs *Datastore) ShardFunc() string {
+	return fs.shardStr
+}
+
+// NormalizeShardFunc returns the canonical SHARDING-file representation
+// of a sharding function given in either short ("prefix/2") or long
+// ("/repo/flatfs/shard/v1/prefix/2") form.
+func NormalizeShardFunc(fun string) string {
+	s, err := parseShardSpec(fun)
+	if err != nil {
+		return fun
+	}
+	return s.String()
+}
+
+func (fs *Datastore) encode(key datastore.Key) (dir, path string) {
+	return fs.encodeAt(fs.path, key)
+}
+
+// encodeAt is encode generalized to an arbitrary root, so Snapshot can lay
+// out its shadow tree (see snapshot.go) using the same shard layout as the
+// live datastore.
+func (fs *Datastore) encodeAt(root string, key datastore.Key) (dir, path string) {
+	noslash := key.String()[1:]
+	dir = filepath.Join(root, fs.getDir(noslash))
+	path = filepath.Join(dir, noslash+extension)
+	return dir, path
+}
+
+func (fs *Datastore) decode(basename string) (datastore.Key, bool) {
+	if filepath.Ext(basename) != extension {
+		return datastore.Key{}, false
+	}
+	name := basename[:len(basename)-len(extension)]
+	return datastore.NewKey(name), true
+}
+
+// Put writes value, which must be a []byte, to the data file for key. The
+// write is atomic: it's staged in a temp file in the target shard
+// directory and only renamed into place once fully written (and, if
+// sync is set, fsynced).
+func (fs *Datastore) Put(key datastore.Key, value interface{}) (err error) {
+	val, ok := value.([]byte)
+	if !ok {
+		return datastore.ErrInvalidType
+	}
+
+	start := time.Now()
+	var fsyncDur, renameDur time.Duration
+	shardDir := fs.getDir(key.String()[1:])
+	defer func() {
+		fs.observer.ObservePut(shardDir, len(val), time.Since(start), fsyncDur, renameDur, err)
+	}()
+
+	fs.shutdownLock.RLock()
+	defer fs.shutdownLock.RUnlock()
+	if fs.shutdown {
+		return ErrClosed
+	}
+
+	dir, path := fs.encode(key)
+	if err := fs.fs.MkdirAll(dir, dirPermission); err != nil {
+		return err
+	}
+
+	tmp, err := fs.fs.TempFile(dir, "put-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer fs.fs.Remove(tmpPath) // no-op once the rename below has succeeded
+
+	framed := fs.checksum.frame(val)
+	if _, err := tmp.Write(framed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if fs.sync {
+		fsyncStart := time.Now()
+		err := tmp.Sync()
+		fsyncDur = time.Since(fsyncStart)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := fs.fs.Chmod(tmpPath, filePermission); err != nil {
+		return err
+	}
+	renameStart := time.Now()
+	err = fs.fs.Rename(tmpPath, path)
+	renameDur = time.Since(renameStart)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Get reads back the value last Put for key, as a []byte. If the
+// datastore was opened with a checksum option, the trailing checksum is
+// verified and stripped; a mismatch is reported as ErrCorrupted rather
+// than the raw (wrong) bytes.
+func (fs *Datastore) Get(key datastore.Key) (value interface{}, err error) {
+	start := time.Now()
+	shardDir := fs.getDir(key.String()[1:])
+	n := 0
+	defer func() {
+		fs.observer.ObserveGet(shardDir, n, time.Since(start), err)
+	}()
+
+	fs.shutdownLock.RLock()
+	defer fs.shutdownLock.RUnlock()
+	if fs.shutdown {
+		return nil, ErrClosed
+	}
+
+	_, path := fs.encode(key)
+	val, read, err := fs.readValue(key, path)
+	n = read
+	return val, err
+}
+
+// readValue opens the data file at path (which must be key's, possibly
+// inside a Snapshot's shadow tree rather than the live shard tree),
+// verifies its checksum if one is configured, and returns the number of
+// bytes actually read off disk alongside the unframed value.
+func (fs *Datastore) readValue(key datastore.Key, path string) ([]byte, int, error) {
+	f, err := fs.fs.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, datastore.ErrNotFound
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	framed, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	val, err := fs.checksum.unframe(framed)
+	if err != nil {
+		return nil, len(framed), &ErrCorrupted{Key: key, Path: path, Err: err}
+	}
+	return val, len(framed), nil
+}
+
+// Has reports whether key has a value in this datastore.
+func (fs *Datastore) Has(key datastore.Key) (has bool, err error) {
+	start := time.Now()
+	shardDir := fs.getDir(key.String()[1:])
+	defer func() {
+		fs.observer.ObserveHas(shardDir, time.Since(start), err)
+	}()
+
+	fs.shutdownLock.RLock()
+	defer fs.shutdownLock.RUnlock()
+	if fs.shutdown {
+		return false, ErrClosed
+	}
+
+	_, path := fs.encode(key)
+
+	_, err = fs.fs.Stat(path)
+	switch {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Delete removes key's data file.
+func (fs *Datastore) Delete(key datastore.Key) (err error) {
+	start := time.Now()
+	shardDir := fs.getDir(key.String()[1:])
+	defer func() {
+		fs.observer.ObserveDelete(shardDir, time.Since(start), err)
+	}()
+
+	fs.shutdownLock.RLock()
+	defer fs.shutdownLock.RUnlock()
+	if fs.shutdown {
+		return ErrClosed
+	}
+
+	_, path := fs.encode(key)
+
+	switch err := fs.fs.Remove(path); {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		return datastore.ErrNotFound
+	default:
+		return err
+	}
+}
+
+// ShardNames returns the name of every shard directory under the
+// datastore root, without reading any of their contents. Delete leaves a
+// shard directory in place, so a shard whose keys have all been deleted
+// is still listed. It's
+// meant for callers that only need the directory layout itself cheaply
+// — e.g. a FUSE mount's root listing — rather than the full keyset that
+// Query would have to walk the whole tree to produce.
+func (fs *Datastore) ShardNames() ([]string, error) {
+	entries, err := fs.fs.ReadDir(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if entry.Name() == snapshotsDir {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// ShardKeys returns the keys stored in a single shard directory (named
+// as ShardNames or a sharding function would), without walking the rest
+// of the tree. It's the scoped counterpart to Query for callers that
+// already know which shard they care about — e.g. a FUSE mount listing
+// one shard subdirectory.
+func (fs *Datastore) ShardKeys(shardDir string) ([]datastore.Key, error) {
+	entries, err := fs.fs.ReadDir(filepath.Join(fs.path, shardDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []datastore.Key
+	for _, entry := range entries {
+		if key, ok := fs.decode(entry.Name()); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Query walks every shard directory and returns the keys (and, unless
+// KeysOnly is set, values) found there. flatfs doesn't support the
+// prefix/filter/order/limit/offset parts of query.Query: none of them is
+// applied, and the results hold the full key set in walk order.
+func (fs *Datastore) Query(q query.Query) (query.Results, error) {
+	fs.shutdownLock.RLock()
+	closed := fs.shutdown
+	fs.shutdownLock.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	return fs.queryUnder(q, fs.path, func(key datastore.Key, _ string) (interface{}, error) {
+		return fs.Get(key)
+	})
+}
+
+// queryUnder runs q against the shard tree rooted at root (fs.path for a
+// live Query, a Snapshot's shadow tree for Snapshot.Query), using get to
+// fetch each matching key's value.
+func (fs *Datastore) queryUnder(q query.Query, root string, get func(datastore.Key, string) (interface{}, error)) (query.Results, error) {
+	start := time.Now()
+	reschan := make(chan query.Result)
+	go func() {
+		defer close(reschan)
+		n := 0
+		var err error
+		defer func() {
+			fs.observer.ObserveQuery(time.Since(start), n, err)
+		}()
+
+		err = fs.walk(root, func(key datastore.Key, path string) error {
+			entry := query.Entry{Key: key.String()}
+			if !q.KeysOnly {
+				val, err := get(key, path)
+				if err != nil {
+					return err
+				}
+				entry.Value = val
+			}
+			n++
+			reschan <- query.Result{Entry: entry}
+			return nil
+		})
+		if err != nil {
+			reschan <- query.Result{Error: err}
+		}
+	}()
+	return query.ResultsWithChan(q, reschan), nil
+}
+
+// walk visits every data file under dir, recursing into subdirectories,
+// and skips the shadow trees Snapshot builds under snapshotsDir so they
+// never leak into a live Query's results.
+func (fs *Datastore) walk(dir string, visit func(datastore.Key, string) error) error {
+	entries, err := fs.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if dir == fs.path && entry.Name() == snapshotsDir {
+			continue
+		}
+		full := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := fs.walk(full, visit); err != nil {
+				return err
+			}
+			continue
+		}
+		key, ok := fs.decode(entry.Name())
+		if !ok {
+			continue // SHARDING, _README, and anything else that isn't a data file
+		}
+		if err := visit(key, full); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close marks the datastore closed; Put, Get, Has, Delete, Query and
+// Batch.Commit called afterwards return ErrClosed. Snapshot, ShardNames
+// and ShardKeys do not check for a closed datastore.
+func (fs *Datastore) Close() error {
+	fs.shutdownLock.Lock()
+	defer fs.shutdownLock.Unlock()
+	fs.shutdown = true
+	return nil
+}
+
+type flatfsBatch struct {
+	ds *Datastore
+
+	puts    map[datastore.Key][]byte
+	deletes map[datastore.Key]struct{}
+}
+
+// Batch returns a datastore.Batch that buffers Puts and Deletes in
+// memory and applies them to disk on Commit.
+func (fs *Datastore) Batch() (datastore.Batch, error) {
+	return &flatfsBatch{
+		ds:      fs,
+		puts:    make(map[datastore.Key][]byte),
+		deletes: make(map[datastore.Key]struct{}),
+	}, nil
+}
+
+func (bt *flatfsBatch) Put(key datastore.Key, value interface{}) error {
+	val, ok := value.([]byte)
+	if !ok {
+		return datastore.ErrInvalidType
+	}
+	delete(bt.deletes, key)
+	bt.puts[key] = val
+	return nil
+}
+
+func (bt *flatfsBatch) Delete(key datastore.Key) error {
+	delete(bt.puts, key)
+	bt.deletes[key] = struct{}{}
+	return nil
+}
+
+func (bt *flatfsBatch) Commit() (err error) {
+	start := time.Now()
+	defer func() {
+		bt.ds.observer.ObserveBatchCommit(time.Since(start), len(bt.puts), len(bt.deletes), err)
+	}()
+
+	bt.ds.shutdownLock.RLock()
+	closed := bt.ds.shutdown
+	bt.ds.shutdownLock.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	for key, val := range bt.puts {
+		if err := bt.ds.Put(key, val); err != nil {
+			return err
+		}
+	}
+	for key := range bt.deletes {
+		if err := bt.ds.Delete(key); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ fmt.Stringer = (*Datastore)(nil)
+
+// String returns a human-readable description of the datastore, mostly
+// useful in logs.
+func (fs *Datastore) String() string {
+	return fmt.Sprintf("flatfs(%s)", fs.path)
+}
@@ -413,6 +413,52 @@ func testBatchDelete(dirFunc string, t *testing.T) {
 
 func TestBatchDelete(t *testing.T) { tryAllShardFuncs(t, testBatchDelete) }
 
+func TestClosedDatastoreRejectsOperations(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	key := datastore.NewKey("foo")
+	if err := fs.Put(key, []byte("bar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close fail: %v\n", err)
+	}
+
+	if err := fs.Put(key, []byte("baz")); err != flatfs.ErrClosed {
+		t.Errorf("Put after Close = %v, want flatfs.ErrClosed", err)
+	}
+	if _, err := fs.Get(key); err != flatfs.ErrClosed {
+		t.Errorf("Get after Close = %v, want flatfs.ErrClosed", err)
+	}
+	if _, err := fs.Has(key); err != flatfs.ErrClosed {
+		t.Errorf("Has after Close = %v, want flatfs.ErrClosed", err)
+	}
+	if err := fs.Delete(key); err != flatfs.ErrClosed {
+		t.Errorf("Delete after Close = %v, want flatfs.ErrClosed", err)
+	}
+	if _, err := fs.Query(query.Query{}); err != flatfs.ErrClosed {
+		t.Errorf("Query after Close = %v, want flatfs.ErrClosed", err)
+	}
+
+	batch, err := fs.Batch()
+	if err != nil {
+		t.Fatalf("Batch fail: %v\n", err)
+	}
+	if err := batch.Put(key, []byte("baz")); err != nil {
+		t.Fatalf("Batch.Put fail: %v\n", err)
+	}
+	if err := batch.Commit(); err != flatfs.ErrClosed {
+		t.Errorf("Batch.Commit after Close = %v, want flatfs.ErrClosed", err)
+	}
+}
+
 func TestSHARDINGFile(t *testing.T) {
 	tempdir, cleanup := tempdir(t)
 	defer cleanup()
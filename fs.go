@@ -0,0 +1,78 @@
+package flatfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// File is the subset of *os.File that flatfs needs from a backend-provided
+// file handle.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// FS abstracts the filesystem operations flatfs performs against a shard
+// tree: creating and renaming data files, making shard directories,
+// reading back the SHARDING/_README bookkeeping files, and hardlinking
+// data files into a Snapshot's shadow tree. All disk access inside this
+// package goes through an FS so that callers can substitute an in-memory
+// backend for tests, a chroot/basepath wrapper for jailing, or a
+// remote/cloud-backed implementation, without flatfs itself knowing the
+// difference.
+type FS interface {
+	Open(path string) (File, error)
+	Create(path string) (File, error)
+	TempFile(dir, pattern string) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+	Link(oldpath, newpath string) error
+	RemoveAll(path string) error
+	Stat(path string) (os.FileInfo, error)
+	Chmod(path string, mode os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(path string) ([]os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+}
+
+// OSBackend is the default FS, backed by the real filesystem. New() uses
+// it implicitly; NewWithFS() lets callers supply a different one.
+type OSBackend struct{}
+
+func (OSBackend) Open(path string) (File, error) { return os.Open(path) }
+
+func (OSBackend) Create(path string) (File, error) { return os.Create(path) }
+
+func (OSBackend) TempFile(dir, pattern string) (File, error) {
+	return ioutil.TempFile(dir, pattern)
+}
+
+func (OSBackend) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+func (OSBackend) Remove(path string) error { return os.Remove(path) }
+
+func (OSBackend) Link(oldpath, newpath string) error { return os.Link(oldpath, newpath) }
+
+func (OSBackend) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+func (OSBackend) Stat(path string) (os.FileInfo, error) { return os.Stat(path) }
+
+func (OSBackend) Chmod(path string, mode os.FileMode) error { return os.Chmod(path, mode) }
+
+func (OSBackend) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+func (OSBackend) ReadDir(path string) ([]os.FileInfo, error) { return ioutil.ReadDir(path) }
+
+func (OSBackend) ReadFile(path string) ([]byte, error) { return ioutil.ReadFile(path) }
+
+func (OSBackend) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(path, data, perm)
+}
+
+var _ FS = OSBackend{}
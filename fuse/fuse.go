@@ -0,0 +1,285 @@
+//go:build fuse
+// +build fuse
+
+// Package fuse mounts a flatfs datastore as a FUSE filesystem. Keys show
+// up as files under a shard-aware directory tree that mirrors the
+// datastore's own SHARDING layout, so listing a shard subdirectory
+// returns exactly the keys that hash into it (matching what
+// Datastore.Query would report for that shard). This lets operators
+// inspect, grep, rsync, or back up a live repo with ordinary filesystem
+// tools instead of the go-datastore API.
+//
+// Build with the "fuse" tag to include this package; it pulls in
+// bazil.org/fuse, which most callers of the core flatfs package don't
+// need.
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+
+	datastore "github.com/ipfs/go-datastore"
+
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	"github.com/ipfs/go-ds-flatfs/shard"
+)
+
+// Mount mounts ds at mountpoint and serves FUSE requests until ctx is
+// canceled or the filesystem is unmounted externally (e.g. `umount`). If
+// rw is false, writes and removes through the mount fail with EROFS.
+func Mount(ctx context.Context, ds *flatfs.Datastore, mountpoint string, rw bool) error {
+	shardId, err := shard.ParseShardFunc(ds.ShardFunc())
+	if err != nil {
+		return fmt.Errorf("fuse: %v", err)
+	}
+
+	opts := []fuse.MountOption{fuse.FSName("flatfs"), fuse.Subtype("flatfs")}
+	if !rw {
+		opts = append(opts, fuse.ReadOnly())
+	}
+
+	c, err := fuse.Mount(mountpoint, opts...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	root := &FS{ds: ds, shard: shardId.Fun, rw: rw}
+
+	errc := make(chan error, 1)
+	go func() { errc <- fusefs.Serve(c, root) }()
+
+	select {
+	case <-ctx.Done():
+		_ = fuse.Unmount(mountpoint)
+		return <-errc
+	case err := <-errc:
+		return err
+	}
+}
+
+// FS is the root of the mounted filesystem.
+type FS struct {
+	ds    *flatfs.Datastore
+	shard shard.Fun
+	rw    bool
+}
+
+func (f *FS) Root() (fusefs.Node, error) {
+	return &dir{fs: f, shard: ""}, nil
+}
+
+// dir is a directory node: either the virtual root (shard == "", one
+// entry per shard) or a single shard directory (one entry per key that
+// hashes into it).
+type dir struct {
+	fs    *FS
+	shard string
+}
+
+func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+// ReadDirAll lists only the shard directory d represents (or, at the
+// root, only the shard names): it uses Datastore.ShardNames/ShardKeys
+// rather than Query, so listing one shard stays O(keys in that shard)
+// instead of an O(total keys) scan of the whole datastore.
+func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	if d.shard == "" {
+		names, err := d.fs.ds.ShardNames()
+		if err != nil {
+			return nil, err
+		}
+		dirents := make([]fuse.Dirent, len(names))
+		for i, s := range names {
+			dirents[i] = fuse.Dirent{Name: s, Type: fuse.DT_Dir}
+		}
+		return dirents, nil
+	}
+
+	keys, err := d.fs.ds.ShardKeys(d.shard)
+	if err != nil {
+		return nil, err
+	}
+	dirents := make([]fuse.Dirent, len(keys))
+	for i, k := range keys {
+		dirents[i] = fuse.Dirent{Name: k.String()[1:], Type: fuse.DT_File}
+	}
+	return dirents, nil
+}
+
+func (d *dir) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	if d.shard == "" {
+		names, err := d.fs.ds.ShardNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range names {
+			if s == name {
+				return &dir{fs: d.fs, shard: name}, nil
+			}
+		}
+		return nil, fuse.ENOENT
+	}
+
+	if d.fs.shard(name) != d.shard {
+		return nil, fuse.ENOENT // name's real shard isn't this directory
+	}
+	key := datastore.NewKey(name)
+	has, err := d.fs.ds.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, fuse.ENOENT
+	}
+	return &file{fs: d.fs, key: key}, nil
+}
+
+func (d *dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	if !d.fs.rw {
+		return fuse.Errno(syscall.EROFS)
+	}
+	if req.Dir {
+		return fuse.Errno(syscall.EPERM) // shard directories aren't removable
+	}
+	return d.fs.ds.Delete(datastore.NewKey(req.Name))
+}
+
+// Create adds a new, initially empty key, so that ordinary tools (touch,
+// cp of a new file, and the create-temp-then-rename-over-target pattern
+// most editors and sed -i/mv use) work against the mount instead of only
+// being able to overwrite or remove keys that already exist. The name's
+// own sharding hash decides where it actually lands (the same as Put
+// always would), regardless of which directory the create happened in —
+// the atomic-rename pattern routinely creates its temp file in whichever
+// directory it found the target in, which need not be the temp name's
+// own shard.
+func (d *dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fusefs.Node, fusefs.Handle, error) {
+	if !d.fs.rw {
+		return nil, nil, fuse.Errno(syscall.EROFS)
+	}
+	if d.shard == "" {
+		return nil, nil, fuse.Errno(syscall.EPERM) // the root only holds shard directories
+	}
+
+	key := datastore.NewKey(req.Name)
+	if err := d.fs.ds.Put(key, []byte{}); err != nil {
+		return nil, nil, err
+	}
+	f := &file{fs: d.fs, key: key, pending: []byte{}, loaded: true}
+	return f, f, nil
+}
+
+// Rename moves a key from req.OldName to req.NewName, which may land in
+// newDir's shard directory, d's, or (per the name's own hash) neither;
+// see Create's doc comment for why that's the right call for the
+// temp-file-then-rename pattern this exists to support.
+func (d *dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fusefs.Node) error {
+	if !d.fs.rw {
+		return fuse.Errno(syscall.EROFS)
+	}
+	if d.shard == "" {
+		return fuse.Errno(syscall.EPERM) // shard directories aren't renameable
+	}
+	if _, ok := newDir.(*dir); !ok {
+		return fuse.Errno(syscall.EPERM)
+	}
+
+	oldKey := datastore.NewKey(req.OldName)
+	val, err := d.fs.ds.Get(oldKey)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	if err := d.fs.ds.Put(datastore.NewKey(req.NewName), val); err != nil {
+		return err
+	}
+	return d.fs.ds.Delete(oldKey)
+}
+
+// file is a single key's data file. Reads return the whole value from a
+// single Get;
+// writes in rw mode are buffered in memory and committed with a single
+// Put on Flush, matching the atomic-whole-value semantics of the
+// underlying datastore. pending is lazily seeded from the key's current
+// value on first Write, so a write at a non-zero offset (a partial or
+// seeked write, which plenty of real tools make against an already-open
+// fd) mutates the existing value instead of a zero-padded blank one.
+type file struct {
+	fs  *FS
+	key datastore.Key
+
+	mu      sync.Mutex
+	pending []byte
+	loaded  bool
+	dirty   bool
+}
+
+func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
+	val, err := f.fs.ds.Get(f.key)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	a.Mode = 0444
+	if f.fs.rw {
+		a.Mode = 0644
+	}
+	a.Size = uint64(len(val.([]byte)))
+	return nil
+}
+
+func (f *file) ReadAll(ctx context.Context) ([]byte, error) {
+	val, err := f.fs.ds.Get(f.key)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	return val.([]byte), nil
+}
+
+func (f *file) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if !f.fs.rw {
+		return fuse.Errno(syscall.EROFS)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.loaded {
+		if val, err := f.fs.ds.Get(f.key); err == nil {
+			f.pending = append([]byte(nil), val.([]byte)...)
+		}
+		f.loaded = true
+	}
+
+	end := int(req.Offset) + len(req.Data)
+	if end > len(f.pending) {
+		grown := make([]byte, end)
+		copy(grown, f.pending)
+		f.pending = grown
+	}
+	copy(f.pending[req.Offset:], req.Data)
+	f.dirty = true
+	resp.Size = len(req.Data)
+	return nil
+}
+
+func (f *file) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirty {
+		return nil
+	}
+	if err := f.fs.ds.Put(f.key, f.pending); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
@@ -0,0 +1,80 @@
+//go:build fuse
+// +build fuse
+
+package fuse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+)
+
+// TestFuseMount mounts a small datastore, lists one shard directory, and
+// confirms the stored key shows up under the shard its name hashes into.
+// Skipped unless /dev/fuse is available, since most CI sandboxes can't
+// mount FUSE filesystems.
+func TestFuseMount(t *testing.T) {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("/dev/fuse not available")
+	}
+
+	dsDir, err := ioutil.TempDir("", "flatfs-fuse-ds-")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dsDir)
+
+	mountDir, err := ioutil.TempDir("", "flatfs-fuse-mnt-")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(mountDir)
+
+	ds, err := flatfs.New(dsDir, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v", err)
+	}
+	if err := ds.Put(datastore.NewKey("quux"), []byte("foobar")); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- Mount(ctx, ds, mountDir, false) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var entries []os.FileInfo
+	for time.Now().Before(deadline) {
+		entries, err = ioutil.ReadDir(filepath.Join(mountDir, "qu"))
+		if err == nil && len(entries) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("ReadDir of shard dir failed: %v", err)
+	}
+
+	found := false
+	for _, e := range entries {
+		if e.Name() == "quux" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected to see key %q under shard dir, saw: %v", "quux", entries)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Mount returned error after unmount: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package flatfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory FS backend: every file lives in a map rather
+// than on disk. It exists so tests (and fuzzing) can exercise flatfs's
+// Put/Get/Query/Scrub/Snapshot logic without the tempdir boilerplate a
+// real OSBackend needs, and is safe for concurrent use.
+type MemFS struct {
+	mu     sync.Mutex
+	files  map[string][]byte
+	dirs   map[string]bool
+	tmpSeq uint64
+}
+
+// NewMemFS returns an empty in-memory backend ready to pass to
+// NewWithFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string][]byte),
+		dirs:  map[string]bool{string(filepath.Separator): true},
+	}
+}
+
+// markDirs records every ancestor of path (not path itself) as an
+// existing directory, stopping at the first one already recorded.
+// Callers pass the path of whatever just came into being (a file, or a
+// directory being MkdirAll'd, which records path itself) so its parents
+// are implicitly created.
+func (m *MemFS) markDirs(path string) {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if m.dirs[dir] {
+			return
+		}
+		m.dirs[dir] = true
+		if dir == filepath.Dir(dir) {
+			return
+		}
+	}
+}
+
+func errNotExist(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+}
+
+type memFile struct {
+	fs     *MemFS
+	name   string
+	buf    *bytes.Buffer // set when opened via Create/TempFile
+	reader *bytes.Reader // set when opened via Open
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, fmt.Errorf("flatfs: memfs file %q not open for reading", f.name)
+	}
+	return f.reader.Read(p)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.buf == nil {
+		return 0, fmt.Errorf("flatfs: memfs file %q not open for writing", f.name)
+	}
+	return f.buf.Write(p)
+}
+
+// Close commits a written file's contents. Unlike a real os.File, a file
+// opened via Create or TempFile doesn't exist in the MemFS (to Open,
+// Rename, Chmod, Stat) until its handle is closed; flatfs's atomic write
+// dance closes the temp file before it Chmods and renames it.
+func (f *memFile) Close() error {
+	if f.buf != nil {
+		f.fs.mu.Lock()
+		f.fs.files[f.name] = append([]byte(nil), f.buf.Bytes()...)
+		f.fs.markDirs(f.name)
+		f.fs.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *memFile) Sync() error { return nil }
+
+func (f *memFile) Stat() (os.FileInfo, error) { return f.fs.Stat(f.name) }
+
+func (m *MemFS) Open(path string) (File, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[path]
+	if !ok {
+		return nil, errNotExist("open", path)
+	}
+	return &memFile{fs: m, name: path, reader: bytes.NewReader(data)}, nil
+}
+
+func (m *MemFS) Create(path string) (File, error) {
+	return &memFile{fs: m, name: filepath.Clean(path), buf: &bytes.Buffer{}}, nil
+}
+
+func (m *MemFS) TempFile(dir, pattern string) (File, error) {
+	m.mu.Lock()
+	m.tmpSeq++
+	name := filepath.Join(dir, fmt.Sprintf("%s%d", pattern, m.tmpSeq))
+	m.mu.Unlock()
+	return &memFile{fs: m, name: name, buf: &bytes.Buffer{}}, nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[oldpath]
+	if !ok {
+		return errNotExist("rename", oldpath)
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = data
+	m.markDirs(newpath)
+	return nil
+}
+
+func (m *MemFS) Remove(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[path]; !ok {
+		return errNotExist("remove", path)
+	}
+	delete(m.files, path)
+	return nil
+}
+
+func (m *MemFS) Link(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[oldpath]
+	if !ok {
+		return errNotExist("link", oldpath)
+	}
+	m.files[newpath] = append([]byte(nil), data...)
+	m.markDirs(newpath)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := path + string(filepath.Separator)
+	for p := range m.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(m.files, p)
+		}
+	}
+	for d := range m.dirs {
+		if d == path || strings.HasPrefix(d, prefix) {
+			delete(m.dirs, d)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Stat(path string) (os.FileInfo, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data, ok := m.files[path]; ok {
+		return memFileInfo{name: filepath.Base(path), size: int64(len(data))}, nil
+	}
+	if m.dirs[path] {
+		return memFileInfo{name: filepath.Base(path), isDir: true}, nil
+	}
+	return nil, errNotExist("stat", path)
+}
+
+func (m *MemFS) Chmod(path string, mode os.FileMode) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[path]; !ok {
+		return errNotExist("chmod", path)
+	}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path = filepath.Clean(path)
+	m.dirs[path] = true
+	m.markDirs(filepath.Join(path, "."))
+	return nil
+}
+
+func (m *MemFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	dir = filepath.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirs[dir] {
+		return nil, errNotExist("readdir", dir)
+	}
+
+	seen := make(map[string]os.FileInfo)
+	for p, data := range m.files {
+		if filepath.Dir(p) == dir {
+			seen[filepath.Base(p)] = memFileInfo{name: filepath.Base(p), size: int64(len(data))}
+		}
+	}
+	for d := range m.dirs {
+		if d != dir && filepath.Dir(d) == dir {
+			seen[filepath.Base(d)] = memFileInfo{name: filepath.Base(d), isDir: true}
+		}
+	}
+
+	entries := make([]os.FileInfo, 0, len(seen))
+	for _, fi := range seen {
+		entries = append(entries, fi)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) ReadFile(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[path]
+	if !ok {
+		return nil, errNotExist("read", path)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (m *MemFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path] = append([]byte(nil), data...)
+	m.markDirs(path)
+	return nil
+}
+
+var _ FS = (*MemFS)(nil)
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi memFileInfo) Name() string { return fi.name }
+func (fi memFileInfo) Size() int64  { return fi.size }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0640
+}
+
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,59 @@
+package flatfs_test
+
+import (
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+)
+
+// TestMemFSBackend exercises Put/Get/Query/Delete against an in-memory
+// FS backend rather than real disk, showing NewWithFS's whole point: no
+// tempdir, no cleanup.
+func TestMemFSBackend(t *testing.T) {
+	fs, err := flatfs.NewWithFS(flatfs.NewMemFS(), "/ds", "prefix/2", false)
+	if err != nil {
+		t.Fatalf("NewWithFS fail: %v\n", err)
+	}
+
+	key := datastore.NewKey("foo")
+	if err := fs.Put(key, []byte("bar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	val, err := fs.Get(key)
+	if err != nil {
+		t.Fatalf("Get fail: %v\n", err)
+	}
+	if string(val.([]byte)) != "bar" {
+		t.Errorf("Get = %q, want %q", val, "bar")
+	}
+
+	has, err := fs.Has(key)
+	if err != nil {
+		t.Fatalf("Has fail: %v\n", err)
+	}
+	if !has {
+		t.Error("Has = false, want true")
+	}
+
+	res, err := fs.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatalf("Query fail: %v\n", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatalf("Query Results.Rest fail: %v\n", err)
+	}
+	if len(entries) != 1 || entries[0].Key != key.String() {
+		t.Errorf("Query = %v, want just %v", entries, key)
+	}
+
+	if err := fs.Delete(key); err != nil {
+		t.Fatalf("Delete fail: %v\n", err)
+	}
+	if _, err := fs.Get(key); err != datastore.ErrNotFound {
+		t.Errorf("Get after Delete = %v, want datastore.ErrNotFound", err)
+	}
+}
@@ -0,0 +1,143 @@
+package flatfs
+
+import (
+	"sync"
+	"time"
+)
+
+// Observer receives a callback after every datastore operation
+// completes, so callers can wire flatfs into a metrics or tracing system
+// (Prometheus, OpenTelemetry, ...) without flatfs depending on either
+// directly. Implementations must be safe for concurrent use: operations
+// run concurrently by design. shard is the shard directory the
+// operation's key hashed into; ObserveQuery and ObserveBatchCommit take
+// no shard, since those operations aren't scoped to a single shard.
+// ObservePut's dur covers the whole
+// call (MkdirAll, staging the temp file, the write, fsyncDur, Chmod, and
+// renameDur); fsyncDur and renameDur are broken out separately because
+// they're the two latency-sensitive steps of the atomic write path.
+type Observer interface {
+	ObservePut(shard string, bytesWritten int, dur, fsyncDur, renameDur time.Duration, err error)
+	ObserveGet(shard string, bytesRead int, dur time.Duration, err error)
+	ObserveHas(shard string, dur time.Duration, err error)
+	ObserveDelete(shard string, dur time.Duration, err error)
+	ObserveQuery(dur time.Duration, resultCount int, err error)
+	ObserveBatchCommit(dur time.Duration, puts, deletes int, err error)
+}
+
+// noopObserver is the Observer every Datastore uses until WithObserver
+// says otherwise.
+type noopObserver struct{}
+
+func (noopObserver) ObservePut(string, int, time.Duration, time.Duration, time.Duration, error) {}
+func (noopObserver) ObserveGet(string, int, time.Duration, error)                               {}
+func (noopObserver) ObserveHas(string, time.Duration, error)                                    {}
+func (noopObserver) ObserveDelete(string, time.Duration, error)                                 {}
+func (noopObserver) ObserveQuery(time.Duration, int, error)                                     {}
+func (noopObserver) ObserveBatchCommit(time.Duration, int, int, error)                           {}
+
+// WithObserver registers obs to receive a callback for every operation
+// on the resulting datastore.
+func WithObserver(obs Observer) Option {
+	return func(fs *Datastore) {
+		fs.observer = obs
+	}
+}
+
+// CounterStats is a point-in-time copy of the counters a CounterObserver
+// has accumulated, safe to read without further synchronization.
+type CounterStats struct {
+	Puts, Gets, Has, Deletes, Queries, BatchCommits uint64
+	BytesWritten, BytesRead                         uint64
+	Errors                                          uint64
+	ShardHits                                       map[string]uint64
+	FsyncTime                                       time.Duration
+	RenameTime                                      time.Duration
+}
+
+// CounterObserver is a minimal, dependency-free Observer: it tallies
+// flatfs_puts_total, flatfs_bytes_written_total, per-shard hit counts
+// (ShardHits, counting Put and Get calls per shard directory; Has and
+// Delete are not counted per shard, and no per-shard file count is kept),
+// and rename/fsync time in memory. It's meant as a starting point for
+// feeding a real metrics system (Prometheus, OpenTelemetry), not a
+// replacement for one.
+type CounterObserver struct {
+	mu    sync.Mutex
+	stats CounterStats
+}
+
+// NewCounterObserver returns a CounterObserver ready to use.
+func NewCounterObserver() *CounterObserver {
+	return &CounterObserver{stats: CounterStats{ShardHits: make(map[string]uint64)}}
+}
+
+// Snapshot returns a copy of the counters accumulated so far.
+func (c *CounterObserver) Snapshot() CounterStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hits := make(map[string]uint64, len(c.stats.ShardHits))
+	for k, v := range c.stats.ShardHits {
+		hits[k] = v
+	}
+	stats := c.stats
+	stats.ShardHits = hits
+	return stats
+}
+
+func (c *CounterObserver) countErr(err error) {
+	if err != nil {
+		c.stats.Errors++
+	}
+}
+
+func (c *CounterObserver) ObservePut(shard string, bytesWritten int, dur, fsyncDur, renameDur time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Puts++
+	c.stats.BytesWritten += uint64(bytesWritten)
+	c.stats.FsyncTime += fsyncDur
+	c.stats.RenameTime += renameDur
+	c.stats.ShardHits[shard]++
+	c.countErr(err)
+}
+
+func (c *CounterObserver) ObserveGet(shard string, bytesRead int, dur time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Gets++
+	c.stats.BytesRead += uint64(bytesRead)
+	c.stats.ShardHits[shard]++
+	c.countErr(err)
+}
+
+func (c *CounterObserver) ObserveHas(shard string, dur time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Has++
+	c.countErr(err)
+}
+
+func (c *CounterObserver) ObserveDelete(shard string, dur time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Deletes++
+	c.countErr(err)
+}
+
+func (c *CounterObserver) ObserveQuery(dur time.Duration, resultCount int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Queries++
+	c.countErr(err)
+}
+
+func (c *CounterObserver) ObserveBatchCommit(dur time.Duration, puts, deletes int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.BatchCommits++
+	c.countErr(err)
+}
+
+var _ Observer = (*CounterObserver)(nil)
+var _ Observer = noopObserver{}
@@ -0,0 +1,79 @@
+package flatfs_test
+
+import (
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+)
+
+func TestCounterObserverTracksOps(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	obs := flatfs.NewCounterObserver()
+	fs, err := flatfs.New(temp, "prefix/2", false, flatfs.WithObserver(obs))
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	key := datastore.NewKey("quux")
+	if err := fs.Put(key, []byte("foobar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+	if _, err := fs.Get(key); err != nil {
+		t.Fatalf("Get fail: %v\n", err)
+	}
+	if _, err := fs.Get(datastore.NewKey("missing")); err == nil {
+		t.Fatalf("expected Get of missing key to fail")
+	}
+
+	stats := obs.Snapshot()
+	if stats.Puts != 1 {
+		t.Errorf("Puts = %d, want 1", stats.Puts)
+	}
+	if stats.Gets != 2 {
+		t.Errorf("Gets = %d, want 2", stats.Gets)
+	}
+	if stats.Errors != 1 {
+		t.Errorf("Errors = %d, want 1", stats.Errors)
+	}
+	if stats.BytesWritten != uint64(len("foobar")) {
+		t.Errorf("BytesWritten = %d, want %d", stats.BytesWritten, len("foobar"))
+	}
+}
+
+// recordingObserver is a minimal Observer that captures the durations
+// ObservePut reports, so tests can check they measure what they claim to.
+type recordingObserver struct {
+	dur, fsyncDur, renameDur time.Duration
+}
+
+func (r *recordingObserver) ObservePut(shard string, bytesWritten int, dur, fsyncDur, renameDur time.Duration, err error) {
+	r.dur, r.fsyncDur, r.renameDur = dur, fsyncDur, renameDur
+}
+func (r *recordingObserver) ObserveGet(string, int, time.Duration, error)      {}
+func (r *recordingObserver) ObserveHas(string, time.Duration, error)           {}
+func (r *recordingObserver) ObserveDelete(string, time.Duration, error)        {}
+func (r *recordingObserver) ObserveQuery(time.Duration, int, error)            {}
+func (r *recordingObserver) ObserveBatchCommit(time.Duration, int, int, error) {}
+
+func TestObservePutRenameDurIsRenameOnly(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	obs := &recordingObserver{}
+	fs, err := flatfs.New(temp, "prefix/2", false, flatfs.WithObserver(obs))
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	if err := fs.Put(datastore.NewKey("quux"), []byte("foobar")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	if obs.renameDur >= obs.dur {
+		t.Errorf("renameDur (%v) should be a fraction of the whole Put's dur (%v), not equal to or larger than it", obs.renameDur, obs.dur)
+	}
+}
@@ -0,0 +1,96 @@
+// Package shard provides the directory-sharding functions flatfs uses to
+// spread keys across subdirectories, plus the (de)serialization needed to
+// round-trip a chosen function through a datastore's SHARDING file.
+package shard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fun maps a key's no-slash string form to the name of the shard
+// directory its data file belongs in.
+type Fun func(noslash string) string
+
+// Shard pairs a Fun with the name and parameter needed to persist it.
+type Shard struct {
+	Fun
+	name  string
+	param int
+}
+
+// String returns the canonical form written to a datastore's SHARDING
+// file, e.g. "/repo/flatfs/shard/v1/next-to-last/2".
+func (s *Shard) String() string {
+	return fmt.Sprintf("/repo/flatfs/shard/v1/%s/%d", s.name, s.param)
+}
+
+// Prefix shards by the first n characters of the key.
+func Prefix(n int) *Shard {
+	return &Shard{
+		Fun: func(noslash string) string {
+			return noslash[:n]
+		},
+		name:  "prefix",
+		param: n,
+	}
+}
+
+// Suffix shards by the last n characters of the key.
+func Suffix(n int) *Shard {
+	return &Shard{
+		Fun: func(noslash string) string {
+			return noslash[len(noslash)-n:]
+		},
+		name:  "suffix",
+		param: n,
+	}
+}
+
+// NextToLast shards by the n characters immediately before the last
+// character of the key. This is the default IPFS sharding function: it
+// spreads multihashes (which tend to share a long common prefix) evenly
+// while leaving the most-distinguishing trailing character out of the
+// shard name.
+func NextToLast(n int) *Shard {
+	return &Shard{
+		Fun: func(noslash string) string {
+			offset := len(noslash) - n - 1
+			return noslash[offset : offset+n]
+		},
+		name:  "next-to-last",
+		param: n,
+	}
+}
+
+// Parse parses the short form accepted by New(), e.g. "prefix/2",
+// "suffix/2" or "next-to-last/2".
+func Parse(str string) (*Shard, error) {
+	parts := strings.Split(str, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid shard identifier: %q", str)
+	}
+
+	param, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard parameter: %q", parts[1])
+	}
+
+	switch parts[0] {
+	case "prefix":
+		return Prefix(param), nil
+	case "suffix":
+		return Suffix(param), nil
+	case "next-to-last":
+		return NextToLast(param), nil
+	default:
+		return nil, fmt.Errorf("unknown shard function: %q", parts[0])
+	}
+}
+
+// ParseShardFunc parses either the short form ("prefix/2") or the long
+// form persisted in a SHARDING file ("/repo/flatfs/shard/v1/prefix/2").
+func ParseShardFunc(str string) (*Shard, error) {
+	return Parse(strings.TrimPrefix(str, "/repo/flatfs/shard/v1/"))
+}
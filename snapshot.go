@@ -0,0 +1,89 @@
+package flatfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+)
+
+// Snapshot is a frozen view of a Datastore as it stood at the moment
+// Snapshot() was called. It's backed by a private shadow tree of
+// hardlinks to every data file that existed at that moment, so Query and
+// Get against it are unaffected by Puts, Deletes, and Batch.Commits that
+// run against the live Datastore afterwards, including ones that touch
+// other keys sharing a shard directory with a snapshotted key. Call
+// Close when done with it to remove the shadow tree.
+type Snapshot struct {
+	ds   *Datastore
+	root string
+}
+
+// Snapshot builds a shadow tree of hardlinks to every data file currently
+// in the datastore and returns a handle for querying it. Building the
+// shadow tree is an O(n) walk of the live tree, so Snapshot is meant for
+// occasional use (backups, consistent long-running iteration), not a
+// per-request operation.
+func (fs *Datastore) Snapshot() (*Snapshot, error) {
+	fs.snapLock.Lock()
+	fs.snapSeq++
+	id := fs.snapSeq
+	fs.snapLock.Unlock()
+
+	root := filepath.Join(fs.path, snapshotsDir, strconv.FormatUint(id, 10))
+	if err := fs.fs.MkdirAll(root, dirPermission); err != nil {
+		return nil, err
+	}
+
+	err := fs.walk(fs.path, func(key datastore.Key, path string) error {
+		dir, linkPath := fs.encodeAt(root, key)
+		if err := fs.fs.MkdirAll(dir, dirPermission); err != nil {
+			return err
+		}
+		if err := fs.fs.Link(path, linkPath); err != nil {
+			if os.IsNotExist(err) {
+				// Raced with a concurrent Delete of this key; it's
+				// gone from the live tree either just before or just
+				// after the moment we captured, so either answer is a
+				// valid snapshot and we simply have nothing to link.
+				return nil
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		fs.fs.RemoveAll(root)
+		return nil, err
+	}
+
+	return &Snapshot{ds: fs, root: root}, nil
+}
+
+// Get reads key as it stood when the snapshot was taken, from the shadow
+// tree of hardlinks built by Snapshot.
+func (s *Snapshot) Get(key datastore.Key) (interface{}, error) {
+	_, path := s.ds.encodeAt(s.root, key)
+	val, _, err := s.ds.readValue(key, path)
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// Query runs q against the datastore as it stood when the snapshot was
+// taken.
+func (s *Snapshot) Query(q query.Query) (query.Results, error) {
+	return s.ds.queryUnder(q, s.root, func(key datastore.Key, path string) (interface{}, error) {
+		val, _, err := s.ds.readValue(key, path)
+		return val, err
+	})
+}
+
+// Close removes the shadow tree of hardlinks backing this snapshot. Get
+// and Query against a closed Snapshot will fail.
+func (s *Snapshot) Close() error {
+	return s.ds.fs.RemoveAll(s.root)
+}
@@ -0,0 +1,164 @@
+package flatfs_test
+
+import (
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+)
+
+func TestSnapshotIsolatesLaterWrites(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	before := datastore.NewKey("before")
+	if err := fs.Put(before, []byte("old")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	snap, err := fs.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot fail: %v\n", err)
+	}
+
+	after := datastore.NewKey("after")
+	if err := fs.Put(after, []byte("new")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	res, err := snap.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatalf("snapshot Query fail: %v\n", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatalf("Query Results.Rest fail: %v\n", err)
+	}
+
+	sawBefore, sawAfter := false, false
+	for _, e := range entries {
+		switch e.Key {
+		case before.String():
+			sawBefore = true
+		case after.String():
+			sawAfter = true
+		}
+	}
+	if !sawBefore {
+		t.Error("snapshot should see the key written before it was taken")
+	}
+	if sawAfter {
+		t.Error("snapshot should not see the key written after it was taken")
+	}
+}
+
+// TestSnapshotIsolatesSameShardWrites guards against a shard-directory
+// granularity bug: Snapshot must keep a key it captured even after that
+// key is deleted and a different key sharing its shard directory is
+// written afterwards.
+func TestSnapshotIsolatesSameShardWrites(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	// "aa1" and "aa2" both hash into the "aa" shard under prefix/2.
+	untouched := datastore.NewKey("aa1")
+	if err := fs.Put(untouched, []byte("old")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	snap, err := fs.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot fail: %v\n", err)
+	}
+	defer snap.Close()
+
+	// After the snapshot was taken, write a sibling key into the same
+	// shard directory, then delete the snapshotted key.
+	sibling := datastore.NewKey("aa2")
+	if err := fs.Put(sibling, []byte("new")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+	if err := fs.Delete(untouched); err != nil {
+		t.Fatalf("Delete fail: %v\n", err)
+	}
+
+	res, err := snap.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatalf("snapshot Query fail: %v\n", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatalf("Query Results.Rest fail: %v\n", err)
+	}
+
+	sawUntouched, sawSibling := false, false
+	for _, e := range entries {
+		switch e.Key {
+		case untouched.String():
+			sawUntouched = true
+		case sibling.String():
+			sawSibling = true
+		}
+	}
+	if !sawUntouched {
+		t.Error("snapshot should still see a key later deleted from its shard, even though a sibling key in the same shard was written after the snapshot")
+	}
+	if sawSibling {
+		t.Error("snapshot should not see a sibling key written into the same shard after the snapshot")
+	}
+}
+
+// TestSnapshotGetHonorsSnapshot checks that Snapshot.Get, like
+// Snapshot.Query, returns the value as it stood at snapshot time rather
+// than forwarding to the live (possibly since-mutated) Datastore.Get.
+func TestSnapshotGetHonorsSnapshot(t *testing.T) {
+	temp, cleanup := tempdir(t)
+	defer cleanup()
+
+	fs, err := flatfs.New(temp, "prefix/2", false)
+	if err != nil {
+		t.Fatalf("New fail: %v\n", err)
+	}
+
+	key := datastore.NewKey("mutated")
+	if err := fs.Put(key, []byte("old")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	snap, err := fs.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot fail: %v\n", err)
+	}
+	defer snap.Close()
+
+	if err := fs.Put(key, []byte("new")); err != nil {
+		t.Fatalf("Put fail: %v\n", err)
+	}
+
+	val, err := snap.Get(key)
+	if err != nil {
+		t.Fatalf("snapshot Get fail: %v\n", err)
+	}
+	if string(val.([]byte)) != "old" {
+		t.Errorf("snapshot Get returned %q, want the pre-snapshot value %q", val, "old")
+	}
+
+	live, err := fs.Get(key)
+	if err != nil {
+		t.Fatalf("live Get fail: %v\n", err)
+	}
+	if string(live.([]byte)) != "new" {
+		t.Errorf("live Get returned %q, want %q", live, "new")
+	}
+}